@@ -1,14 +1,36 @@
 package meritop
 
 import (
-	"io/ioutil"
+	"fmt"
 	"log"
+	"math/rand"
 	"net"
-	"net/http"
-	"net/url"
 	"strconv"
+	"sync"
+	"time"
 
-	"github.com/coreos/go-etcd/etcd"
+	"golang.org/x/net/context"
+
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding/gzip"
+
+	"github.com/5kg/taskgraph/pkg/capability"
+	"github.com/5kg/taskgraph/pkg/etcdutil"
+	"github.com/5kg/taskgraph/pkg/rpc"
+)
+
+// defaultCapabilities is published when the framework isn't given an
+// explicit capability set via WithCapabilities.
+var defaultCapabilities = []string{"grpc-v1"}
+
+// Defaults for the DataRequest worker pools and retry policy; see
+// WithWorkerPool and WithRetryPolicy.
+const (
+	defaultWorkerPoolSize = 8
+	defaultMaxRetries     = 3
+	defaultBaseBackoff    = 100 * time.Millisecond
 )
 
 type taskRole int
@@ -19,12 +41,6 @@ const (
 	childRole
 )
 
-const (
-	DataRequestPrefix string = "/datareq"
-	DataRequestTaskID string = "taskID"
-	DataRequestReq    string = "req"
-)
-
 // This interface is used by application during taskgraph configuration phase.
 type Bootstrap interface {
 	// These allow application developer to set the task configuration so framework
@@ -64,11 +80,20 @@ type Framework interface {
 
 	GetLogger() log.Logger
 
-	// Request data from parent or children.
-	DataRequest(toID uint64, meta string)
+	// Request data from parent or children. ctx carries the caller's
+	// cancellation; DataRequest also aborts early if the current epoch
+	// advances or the framework exits before a response arrives.
+	DataRequest(ctx context.Context, toID uint64, meta string)
 
 	// This is used to figure out taskid for current node
 	GetTaskID() uint64
+
+	// HasCapability reports whether peerID has advertised the given
+	// capability string. MinClusterCapability reports whether every
+	// currently known member of the job has. Both are backed by a watch
+	// over etcd, so they reflect membership changes without polling.
+	HasCapability(peerID uint64, cap string) bool
+	MinClusterCapability(cap string) bool
 }
 
 type framework struct {
@@ -80,13 +105,60 @@ type framework struct {
 	task     Task
 	topology Topology
 
-	taskID       uint64
-	epoch        uint64
-	etcdClient   *etcd.Client
-	stops        []chan bool
-	ln           net.Listener
-	addressMap   map[uint64]string // taskId -> node address. Maybe in etcd later.
-	dataRespChan chan *dataResponse
+	taskID     uint64
+	etcdClient *clientv3.Client
+	stops      []chan bool
+
+	// metaStopsMu guards metaStops against concurrent teardown/replace:
+	// advanceEpoch (the watchEpoch goroutine) rewires it on every new
+	// epoch, while stop() (the caller's goroutine) tears it down on
+	// exit, and the two can otherwise race to close the same channel.
+	metaStopsMu sync.Mutex
+	metaStops   []chan bool // watchAll stops for the current epoch's parent/child sets
+	ln          net.Listener
+	addressMap map[uint64]string // taskId -> node address. Maybe in etcd later.
+
+	// sendCh/dispatchCh feed the DataRequest worker pools; see start() and
+	// WithWorkerPool. pending coalesces concurrent DataRequest calls for
+	// the same (toID, req, epoch) onto a single in-flight RPC.
+	sendWorkers     int
+	dispatchWorkers int
+	maxRetries      int
+	baseBackoff     time.Duration
+	sendCh          chan *dataRequestJob
+	dispatchCh      chan *dataResponse
+
+	pendingMu sync.Mutex
+	pending   map[dataRequestKey]context.CancelFunc
+
+	// ctx is cancelled on Exit; epochCtx is derived from ctx and is
+	// additionally cancelled every time SetEpoch advances, so that
+	// in-flight operations tied to a stale epoch unwind promptly.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// epochMu guards epoch, epochCtx and epochCancel, which are mutated
+	// by SetEpoch (called from both the task goroutine and the
+	// watchEpoch goroutine via advanceEpoch) while being read from
+	// caller and worker goroutines in DataRequest and parentOrChild.
+	epochMu     sync.RWMutex
+	epoch       uint64
+	epochCtx    context.Context
+	epochCancel context.CancelFunc
+
+	// dataInterceptors are chained, in order, around every inbound
+	// TaskData RPC; see WithDataInterceptors.
+	dataInterceptors []grpc.UnaryServerInterceptor
+	grpcServer       *grpc.Server
+
+	connsMu sync.Mutex
+	conns   map[uint64]*grpc.ClientConn
+
+	// buildVersion/capSet are published at startup under this task's
+	// capability key; see WithCapabilities and capability.Registry.
+	buildVersion string
+	capSet       []string
+	caps         *capability.Registry
 }
 
 type dataResponse struct {
@@ -95,14 +167,110 @@ type dataResponse struct {
 	data   []byte
 }
 
+// dataRequestKey identifies one logical DataRequest: a given req string
+// addressed to toID within a given epoch. Concurrent DataRequest calls
+// that share a key are coalesced onto a single in-flight RPC.
+type dataRequestKey struct {
+	toID  uint64
+	req   string
+	epoch uint64
+}
+
+// dataRequestJob is handed to the send worker pool; call is already bound
+// to the right RPC method (ServeAsChild/ServeAsParent) for toID.
+type dataRequestJob struct {
+	ctx  context.Context
+	key  dataRequestKey
+	call func(context.Context, *rpc.DataRequest, ...grpc.CallOption) (*rpc.DataResponse, error)
+}
+
+// WithDataInterceptors appends unary server interceptors to the chain
+// wrapped around every inbound TaskData RPC (ServeAsChild/ServeAsParent).
+// Interceptors run in the order given, outermost first, and can be used to
+// add metrics, mTLS auth, log correlation IDs, and the like without the
+// framework itself knowing about any of them.
+func (f *framework) WithDataInterceptors(interceptors ...grpc.UnaryServerInterceptor) *framework {
+	f.dataInterceptors = append(f.dataInterceptors, interceptors...)
+	return f
+}
+
+// WithCapabilities sets the build version and feature strings this
+// framework instance publishes at startup, overriding defaultCapabilities.
+func (f *framework) WithCapabilities(version string, caps ...string) *framework {
+	f.buildVersion = version
+	f.capSet = caps
+	return f
+}
+
+// WithWorkerPool sizes the worker pools behind DataRequest: sendWorkers
+// bounds how many outbound RPCs run concurrently, and dispatchWorkers
+// bounds how many ParentDataReady/ChildDataReady callbacks run
+// concurrently. Zero leaves the default (defaultWorkerPoolSize) in place.
+func (f *framework) WithWorkerPool(sendWorkers, dispatchWorkers int) *framework {
+	f.sendWorkers = sendWorkers
+	f.dispatchWorkers = dispatchWorkers
+	return f
+}
+
+// WithRetryPolicy overrides the number of retries and the base backoff
+// DataRequest uses on transient RPC failures before giving up and calling
+// Task.DataRequestFailed.
+func (f *framework) WithRetryPolicy(maxRetries int, baseBackoff time.Duration) *framework {
+	f.maxRetries = maxRetries
+	f.baseBackoff = baseBackoff
+	return f
+}
+
+// chainUnaryInterceptors composes a slice of unary server interceptors
+// into a single one that invokes them in order, each wrapping the next,
+// mirroring etcd v3rpc's interceptor chaining.
+func chainUnaryInterceptors(interceptors []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	switch len(interceptors) {
+	case 0:
+		return nil
+	case 1:
+		return interceptors[0]
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			chained = bindUnaryInterceptor(interceptors[i], info, chained)
+		}
+		return chained(ctx, req)
+	}
+}
+
+func bindUnaryInterceptor(interceptor grpc.UnaryServerInterceptor, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) grpc.UnaryHandler {
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		return interceptor(ctx, req, info, handler)
+	}
+}
+
+// MakeTaskMetaPrefix returns the keyspace prefix under which every task's
+// parent/child meta-ready keys for the given job name live, so a single
+// watch can be established for all of them at once.
+func MakeTaskMetaPrefix(name string) string {
+	return fmt.Sprintf("/%s/", name)
+}
+
+// currentEpoch returns a consistent snapshot of the epoch number together
+// with the context derived for it, so callers never pair an epoch with a
+// stale or newer epochCtx.
+func (f *framework) currentEpoch() (uint64, context.Context) {
+	f.epochMu.RLock()
+	defer f.epochMu.RUnlock()
+	return f.epoch, f.epochCtx
+}
+
 func (f *framework) parentOrChild(taskID uint64) taskRole {
-	for _, id := range f.topology.GetParents(f.epoch) {
+	epoch, _ := f.currentEpoch()
+	for _, id := range f.topology.GetParents(epoch) {
 		if taskID == id {
 			return parentRole
 		}
 	}
 
-	for _, id := range f.topology.GetChildren(f.epoch) {
+	for _, id := range f.topology.GetChildren(epoch) {
 		if taskID == id {
 			return childRole
 		}
@@ -111,75 +279,135 @@ func (f *framework) parentOrChild(taskID uint64) taskRole {
 }
 
 func (f *framework) start() {
-	f.etcdClient = etcd.NewClient(f.etcdURLs)
+	var err error
+	f.etcdClient, err = clientv3.New(clientv3.Config{Endpoints: f.etcdURLs})
+	if err != nil {
+		log.Fatalf("clientv3.New() returns error: %v", err)
+	}
 	f.topology.SetTaskID(f.taskID)
 	f.epoch = 0
 	f.stops = make([]chan bool, 0)
-	f.dataRespChan = make(chan *dataResponse, 100)
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+	f.epochCtx, f.epochCancel = context.WithCancel(f.ctx)
+	f.conns = make(map[uint64]*grpc.ClientConn)
+
+	if f.sendWorkers == 0 {
+		f.sendWorkers = defaultWorkerPoolSize
+	}
+	if f.dispatchWorkers == 0 {
+		f.dispatchWorkers = defaultWorkerPoolSize
+	}
+	if f.maxRetries == 0 {
+		f.maxRetries = defaultMaxRetries
+	}
+	if f.baseBackoff == 0 {
+		f.baseBackoff = defaultBaseBackoff
+	}
+	f.pending = make(map[dataRequestKey]context.CancelFunc)
+	f.sendCh = make(chan *dataRequestJob, 100)
+	f.dispatchCh = make(chan *dataResponse, 100)
+	for i := 0; i < f.sendWorkers; i++ {
+		go f.sendWorker()
+	}
+	for i := 0; i < f.dispatchWorkers; i++ {
+		go f.dispatchWorker()
+	}
 
 	// setup etcd watches
 	// - create self's parent and child meta flag
 	// - watch parents' child meta flag
 	// - watch children's parent meta flag
-	f.etcdClient.Create(MakeParentMetaPath(f.name, f.GetTaskID()), "", 0)
-	f.etcdClient.Create(MakeChildMetaPath(f.name, f.GetTaskID()), "", 0)
-	parentStops := f.watchAll(parentRole, f.topology.GetParents(f.epoch))
-	childStops := f.watchAll(childRole, f.topology.GetChildren(f.epoch))
-	f.stops = append(f.stops, parentStops...)
-	f.stops = append(f.stops, childStops...)
+	f.etcdClient.Put(context.Background(), MakeParentMetaPath(f.name, f.GetTaskID()), "")
+	f.etcdClient.Put(context.Background(), MakeChildMetaPath(f.name, f.GetTaskID()), "")
+	parentStop := f.watchAll(parentRole, f.topology.GetParents(f.epoch))
+	childStop := f.watchAll(childRole, f.topology.GetChildren(f.epoch))
+	f.replaceMetaStops([]chan bool{parentStop, childStop})
+	f.stops = append(f.stops, f.watchEpoch())
+
+	caps := f.capSet
+	if len(caps) == 0 {
+		caps = defaultCapabilities
+	}
+	if err := capability.Publish(context.Background(), f.etcdClient, f.name, f.taskID, capability.NewSet(f.buildVersion, caps...)); err != nil {
+		log.Printf("framework: publishing capabilities failed: %v", err)
+	}
+	f.caps = capability.NewRegistry(f.etcdClient, f.name)
+	go func() {
+		if err := f.caps.Watch(f.ctx); err != nil && f.ctx.Err() == nil {
+			log.Printf("framework: capability watch stopped: %v", err)
+		}
+	}()
 
-	go f.startHttpServerForDataRequest()
-	go f.dataResponseEventLoop()
+	go f.startGrpcServerForDataRequest()
 
 	// After framework init finished, it should init task.
 	f.task.SetEpoch(f.epoch)
 	f.task.Init(f.taskID, f, nil)
 }
 
-func newDataReqHandler(f *framework) http.Handler {
-	mux := http.NewServeMux()
-	mux.HandleFunc(DataRequestPrefix, func(w http.ResponseWriter, r *http.Request) {
-		q := r.URL.Query()
-		fromIDStr := q.Get(DataRequestTaskID)
-		fromID, err := strconv.ParseUint(fromIDStr, 0, 64)
-		if err != nil {
-			log.Fatalf("taskID in query couldn't be parsed: %s", fromIDStr)
-		}
-		req := q.Get(DataRequestReq)
-		var serveData func(uint64, string) []byte
-		switch f.parentOrChild(fromID) {
-		case parentRole:
-			serveData = f.task.ServeAsChild
-		case childRole:
-			serveData = f.task.ServeAsParent
-		default:
-			panic("unimplemented")
-		}
-		d := serveData(fromID, req)
+// ServeAsChild implements rpc.TaskDataServer: it is invoked when a peer
+// that this task considers its parent asks for data, so the call is routed
+// to the task's child-side callback.
+//
+// ctx is intentionally not threaded through to Task.ServeAsChild: Task is
+// defined outside this package, and propagating cancellation into the
+// callback requires widening its signature to accept a context.Context,
+// which is a breaking change to every implementation of Task and is left
+// to a follow-up change to that interface rather than done silently here.
+func (f *framework) ServeAsChild(ctx context.Context, in *rpc.DataRequest) (*rpc.DataResponse, error) {
+	return &rpc.DataResponse{Data: f.task.ServeAsChild(in.FromId, in.Req)}, nil
+}
 
-		if _, err := w.Write(d); err != nil {
-			log.Printf("response write errored: %v", err)
-		}
-	})
-	return mux
+// ServeAsParent implements rpc.TaskDataServer: it is invoked when a peer
+// that this task considers its child asks for data, so the call is routed
+// to the task's parent-side callback. See ServeAsChild for why ctx isn't
+// threaded through yet.
+func (f *framework) ServeAsParent(ctx context.Context, in *rpc.DataRequest) (*rpc.DataResponse, error) {
+	return &rpc.DataResponse{Data: f.task.ServeAsParent(in.FromId, in.Req)}, nil
 }
 
-// Framework http server for data request.
-// Each request will be in the format: "/datareq/{taskID}/{req}".
-// "taskID" indicates the requesting task. "req" is the meta data for this request.
-// On success, it should respond with requested data in http body.
-func (f *framework) startHttpServerForDataRequest() {
-	log.Printf("framework: serving http data request on %s", f.ln.Addr())
-	if err := http.Serve(f.ln, newDataReqHandler(f)); err != nil {
-		log.Fatalf("http.Serve() returns error: %v\n", err)
+// startGrpcServerForDataRequest replaces the old ad-hoc "/datareq" HTTP
+// transport with the TaskData gRPC service, wrapped in whatever
+// interceptors were registered via WithDataInterceptors.
+func (f *framework) startGrpcServerForDataRequest() {
+	var opts []grpc.ServerOption
+	if chained := chainUnaryInterceptors(f.dataInterceptors); chained != nil {
+		opts = append(opts, grpc.UnaryInterceptor(chained))
+	}
+	f.grpcServer = grpc.NewServer(opts...)
+	rpc.RegisterTaskDataServer(f.grpcServer, f)
+
+	log.Printf("framework: serving grpc data request on %s", f.ln.Addr())
+	if err := f.grpcServer.Serve(f.ln); err != nil {
+		log.Printf("grpc server.Serve() returns error: %v", err)
 	}
 }
 
-// Framework event loop handles data response for requests sent in DataRequest().
-func (f *framework) dataResponseEventLoop() {
-	for {
-		dataResp := <-f.dataRespChan
+// dialTaskData returns a cached gRPC connection to toID's data plane,
+// dialing lazily on first use.
+func (f *framework) dialTaskData(toID uint64) (*grpc.ClientConn, error) {
+	f.connsMu.Lock()
+	defer f.connsMu.Unlock()
+	if conn, ok := f.conns[toID]; ok {
+		return conn, nil
+	}
+	addr, ok := f.addressMap[toID]
+	if !ok {
+		return nil, fmt.Errorf("ID = %d not found", toID)
+	}
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	f.conns[toID] = conn
+	return conn, nil
+}
 
+// dispatchWorker drains dispatchCh and invokes the matching task callback
+// for each completed DataRequest. A fixed pool of these, rather than a
+// goroutine per response, bounds how many callbacks can run at once.
+func (f *framework) dispatchWorker() {
+	for dataResp := range f.dispatchCh {
 		var dataReady func(uint64, string, []byte)
 		switch f.parentOrChild(dataResp.taskID) {
 		case parentRole:
@@ -187,115 +415,351 @@ func (f *framework) dataResponseEventLoop() {
 		case childRole:
 			dataReady = f.task.ChildDataReady
 		default:
-			panic("unimplemented")
+			// The epoch advanced between this response being
+			// enqueued and dispatched, and taskID is no longer a
+			// parent or child under the new topology. Drop it
+			// rather than fire a callback for a relationship that
+			// no longer exists.
+			log.Printf("framework: dropping data response from %d: no longer a parent or child", dataResp.taskID)
+			continue
 		}
+		dataReady(dataResp.taskID, dataResp.req, dataResp.data)
+	}
+}
 
-		go dataReady(dataResp.taskID, dataResp.req, dataResp.data)
+// sendWorker drains sendCh and runs each job to completion (including
+// retries) before picking up the next one. A fixed pool of these bounds
+// how many outbound RPCs are in flight at once.
+func (f *framework) sendWorker() {
+	for job := range f.sendCh {
+		f.sendWithRetry(job)
 	}
 }
 
+// sendWithRetry runs job, retrying transient failures with exponential
+// backoff and jitter up to f.maxRetries times. On success it hands the
+// response to dispatchCh; on terminal failure it calls
+// Task.DataRequestFailed. If job.ctx is done — because the caller
+// cancelled, the epoch advanced, or the framework exited — it gives up
+// silently, since a stale-epoch response should not fire task callbacks.
+func (f *framework) sendWithRetry(job *dataRequestJob) {
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		if job.ctx.Err() != nil {
+			lastErr = job.ctx.Err()
+			break
+		}
+
+		out, err := job.call(job.ctx, &rpc.DataRequest{FromId: f.taskID, Req: job.key.req})
+		if err == nil {
+			select {
+			case f.dispatchCh <- &dataResponse{taskID: job.key.toID, req: job.key.req, data: out.Data}:
+			case <-job.ctx.Done():
+			}
+			f.completeDataRequest(job.key)
+			return
+		}
+		lastErr = err
+
+		if attempt >= f.maxRetries {
+			break
+		}
+		select {
+		case <-time.After(backoffWithJitter(f.baseBackoff, attempt)):
+		case <-job.ctx.Done():
+			lastErr = job.ctx.Err()
+		}
+	}
+
+	cancelled := job.ctx.Err() != nil
+	f.completeDataRequest(job.key)
+	if !cancelled {
+		f.task.DataRequestFailed(job.key.toID, job.key.req, lastErr)
+	}
+}
+
+// completeDataRequest removes key from the in-flight table and cancels
+// its context, so a later DataRequest with the same key issues a new RPC.
+func (f *framework) completeDataRequest(key dataRequestKey) {
+	f.pendingMu.Lock()
+	cancel, ok := f.pending[key]
+	delete(f.pending, key)
+	f.pendingMu.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+// backoffWithJitter returns a random duration in [d/2, d] where
+// d = base * 2^attempt, i.e. exponential backoff with jitter over the
+// upper half of each step.
+func backoffWithJitter(base time.Duration, attempt int) time.Duration {
+	d := base * time.Duration(int64(1)<<uint(attempt))
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
 func (f *framework) stop() {
 	for _, c := range f.stops {
 		close(c)
 	}
+	f.closeMetaStops()
+	if f.grpcServer != nil {
+		f.grpcServer.GracefulStop()
+	}
+}
+
+// replaceMetaStops installs newStops as f.metaStops and tears down
+// whichever stops were previously installed. Swapping the slice under
+// metaStopsMu, rather than closing in place, is what keeps a concurrent
+// advanceEpoch and stop() from both closing the same channel.
+func (f *framework) replaceMetaStops(newStops []chan bool) {
+	f.metaStopsMu.Lock()
+	old := f.metaStops
+	f.metaStops = newStops
+	f.metaStopsMu.Unlock()
+	for _, c := range old {
+		close(c)
+	}
+}
+
+// closeMetaStops tears down whatever metaStops are currently installed and
+// clears the field, so a racing advanceEpoch installs fresh watches via
+// replaceMetaStops instead of closing a channel stop() already closed.
+func (f *framework) closeMetaStops() {
+	f.metaStopsMu.Lock()
+	stops := f.metaStops
+	f.metaStops = nil
+	f.metaStopsMu.Unlock()
+	for _, c := range stops {
+		close(c)
+	}
 }
 
 func (f *framework) FlagParentMetaReady(meta string) {
-	f.etcdClient.Set(
+	f.etcdClient.Put(
+		context.Background(),
 		MakeParentMetaPath(f.name, f.GetTaskID()),
-		meta,
-		0)
+		meta)
 }
 
 func (f *framework) FlagChildMetaReady(meta string) {
-	f.etcdClient.Set(
+	f.etcdClient.Put(
+		context.Background(),
 		MakeChildMetaPath(f.name, f.GetTaskID()),
-		meta,
-		0)
+		meta)
 }
 
 func (f *framework) SetEpoch(epoch uint64) {
+	f.epochMu.Lock()
+	oldCancel := f.epochCancel
 	f.epoch = epoch
+	f.epochCtx, f.epochCancel = context.WithCancel(f.ctx)
+	f.epochMu.Unlock()
+	oldCancel()
 }
 
-func (f *framework) watchAll(who taskRole, taskIDs []uint64) []chan bool {
-	stops := make([]chan bool, len(taskIDs))
-
-	for i, taskID := range taskIDs {
-		receiver := make(chan *etcd.Response, 10)
-		stop := make(chan bool, 1)
-		stops[i] = stop
-
-		var watchPath string
-		var taskCallback func(uint64, string)
-		switch who {
-		case parentRole:
-			// Watch parent's child.
-			watchPath = MakeChildMetaPath(f.name, taskID)
-			taskCallback = f.task.ParentMetaReady
-		case childRole:
-			// Watch child's parent.
-			watchPath = MakeParentMetaPath(f.name, taskID)
-			taskCallback = f.task.ChildMetaReady
-		default:
-			panic("unimplemented")
+// watchAll multiplexes the meta-ready watches for all given taskIDs onto a
+// single clientv3.Watcher, rather than one etcd watch per taskID. It keys
+// off of a common keyspace prefix and dispatches each PUT event to the
+// taskCallback for the matching role. The stop channel, when closed, tears
+// down the watch goroutine.
+func (f *framework) watchAll(who taskRole, taskIDs []uint64) chan bool {
+	stop := make(chan bool, 1)
+
+	watchPaths := make(map[string]uint64, len(taskIDs))
+	var taskCallback func(uint64, string)
+	switch who {
+	case parentRole:
+		// Watch parents' child meta.
+		taskCallback = f.task.ParentMetaReady
+		for _, taskID := range taskIDs {
+			watchPaths[MakeChildMetaPath(f.name, taskID)] = taskID
+		}
+	case childRole:
+		// Watch children's parent meta.
+		taskCallback = f.task.ChildMetaReady
+		for _, taskID := range taskIDs {
+			watchPaths[MakeParentMetaPath(f.name, taskID)] = taskID
 		}
+	default:
+		panic("unimplemented")
+	}
 
-		go f.etcdClient.Watch(watchPath, 0, false, receiver, stop)
-		go func(receiver <-chan *etcd.Response, taskID uint64) {
-			for {
-				resp, ok := <-receiver
-				if !ok {
+	go func() {
+		rev := int64(0)
+		for {
+			ctx, cancel := context.WithCancel(context.Background())
+			opts := []clientv3.OpOption{clientv3.WithPrefix()}
+			if rev > 0 {
+				opts = append(opts, clientv3.WithRev(rev))
+			}
+			rch := f.etcdClient.Watch(ctx, MakeTaskMetaPrefix(f.name), opts...)
+
+			disconnected := false
+			for !disconnected {
+				select {
+				case wresp, ok := <-rch:
+					if !ok {
+						disconnected = true
+						break
+					}
+					if wresp.Err() != nil {
+						log.Printf("framework: watch on %s errored: %v", f.name, wresp.Err())
+						disconnected = true
+						break
+					}
+					rev = wresp.Header.Revision + 1
+					for _, ev := range wresp.Events {
+						if ev.Type != mvccpb.PUT {
+							continue
+						}
+						taskID, ok := watchPaths[string(ev.Kv.Key)]
+						if !ok {
+							continue
+						}
+						taskCallback(taskID, string(ev.Kv.Value))
+					}
+				case <-stop:
+					cancel()
 					return
 				}
-				if resp.Action != "set" {
-					continue
+			}
+			cancel()
+		}
+	}()
+	return stop
+}
+
+// watchEpoch watches EpochPath for new epochs published by the elected
+// reassignment coordinator and turns each one into an advanceEpoch call.
+func (f *framework) watchEpoch() chan bool {
+	stop := make(chan bool, 1)
+	key := etcdutil.EpochPath(f.name)
+
+	go func() {
+		rev := int64(0)
+		for {
+			ctx, cancel := context.WithCancel(context.Background())
+			var opts []clientv3.OpOption
+			if rev > 0 {
+				opts = append(opts, clientv3.WithRev(rev))
+			}
+			rch := f.etcdClient.Watch(ctx, key, opts...)
+
+			disconnected := false
+			for !disconnected {
+				select {
+				case wresp, ok := <-rch:
+					if !ok {
+						disconnected = true
+						break
+					}
+					if wresp.Err() != nil {
+						log.Printf("framework: epoch watch errored: %v", wresp.Err())
+						disconnected = true
+						break
+					}
+					rev = wresp.Header.Revision + 1
+					for _, ev := range wresp.Events {
+						if ev.Type != mvccpb.PUT {
+							continue
+						}
+						epoch, err := strconv.ParseUint(string(ev.Kv.Value), 10, 64)
+						if err != nil {
+							log.Printf("framework: malformed epoch %q: %v", ev.Kv.Value, err)
+							continue
+						}
+						f.advanceEpoch(epoch)
+					}
+				case <-stop:
+					cancel()
+					return
 				}
-				taskCallback(taskID, resp.Node.Value)
 			}
-		}(receiver, taskID)
-	}
-	return stops
+			cancel()
+		}
+	}()
+	return stop
 }
 
-func (f *framework) DataRequest(toID uint64, req string) {
-	// getAddressFromTaskID
-	addr, ok := f.addressMap[toID]
-	if !ok {
-		log.Fatalf("ID = %d not found", toID)
+// advanceEpoch re-derives the parent/child sets for the new epoch from
+// topology, rewires their meta-ready watches, and notifies the task.
+func (f *framework) advanceEpoch(epoch uint64) {
+	f.SetEpoch(epoch)
+	parentStop := f.watchAll(parentRole, f.topology.GetParents(epoch))
+	childStop := f.watchAll(childRole, f.topology.GetChildren(epoch))
+	f.replaceMetaStops([]chan bool{parentStop, childStop})
+	f.task.SetEpoch(epoch)
+}
+
+// DataRequest enqueues a request for data from toID, to be served by a
+// worker in the send pool. A call with the same (toID, req) already
+// in flight within the current epoch is coalesced onto the existing
+// attempt rather than issuing a second RPC.
+func (f *framework) DataRequest(ctx context.Context, toID uint64, req string) {
+	epoch, epochCtx := f.currentEpoch()
+	key := dataRequestKey{toID: toID, req: req, epoch: epoch}
+
+	f.pendingMu.Lock()
+	if _, inFlight := f.pending[key]; inFlight {
+		f.pendingMu.Unlock()
 		return
 	}
-	u := url.URL{
-		Scheme: "http",
-		Host:   addr,
-		Path:   DataRequestPrefix,
-	}
-	q := u.Query()
-	q.Add(DataRequestTaskID, strconv.FormatUint(f.taskID, 10))
-	q.Add(DataRequestReq, req)
-	u.RawQuery = q.Encode()
-	urlStr := u.String()
-	// send request
-	// pass the response to the awaiting event loop for data response
-	go func(urlStr string) {
-		resp, err := http.Get(urlStr)
-		if err != nil {
-			log.Fatalf("http.Get(%s) returns error: %v", urlStr, err)
-		}
-		if resp.StatusCode != 200 {
-			log.Fatalf("response code = %d, assume = %d", resp.StatusCode, 200)
-		}
-		data, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			log.Fatalf("ioutil.ReadAll(%v) returns error: %v", resp.Body, err)
+	// reqCtx is cancelled when the caller's ctx is done, the current
+	// epoch advances, or the framework exits — whichever comes first.
+	reqCtx, cancel := context.WithCancel(epochCtx)
+	f.pending[key] = cancel
+	f.pendingMu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-reqCtx.Done():
 		}
-		dataResp := &dataResponse{
-			taskID: toID,
-			req:    req,
-			data:   data,
+	}()
+
+	conn, err := f.dialTaskData(toID)
+	if err != nil {
+		f.completeDataRequest(key)
+		f.task.DataRequestFailed(toID, req, err)
+		return
+	}
+	client := rpc.NewTaskDataClient(conn)
+
+	// Whichever role toID plays to us, the remote will serve us as the
+	// opposite role from its own point of view, so pick the matching RPC.
+	var call func(context.Context, *rpc.DataRequest, ...grpc.CallOption) (*rpc.DataResponse, error)
+	switch f.parentOrChild(toID) {
+	case parentRole:
+		call = client.ServeAsParent
+	case childRole:
+		call = client.ServeAsChild
+	default:
+		// The epoch advanced between the caller deciding to request
+		// from toID and this call resolving its role, and toID is no
+		// longer a parent or child under the new topology. Fail the
+		// request rather than crash the process.
+		f.completeDataRequest(key)
+		f.task.DataRequestFailed(toID, req, fmt.Errorf("taskgraph: %d is no longer a parent or child", toID))
+		return
+	}
+
+	// Only ask for a compressed response if every member of the job has
+	// advertised support for it; otherwise an older peer would reject it.
+	if f.MinClusterCapability("gzip-data") {
+		wrapped := call
+		call = func(ctx context.Context, in *rpc.DataRequest, opts ...grpc.CallOption) (*rpc.DataResponse, error) {
+			return wrapped(ctx, in, append(opts, grpc.UseCompressor(gzip.Name))...)
 		}
-		f.dataRespChan <- dataResp
-	}(urlStr)
+	}
+
+	select {
+	case f.sendCh <- &dataRequestJob{ctx: reqCtx, key: key, call: call}:
+	case <-reqCtx.Done():
+		f.completeDataRequest(key)
+	}
 }
 
 func (f *framework) GetTopology() Topology {
@@ -303,6 +767,7 @@ func (f *framework) GetTopology() Topology {
 }
 
 func (f *framework) Exit() {
+	f.cancel()
 }
 
 func (f *framework) GetLogger() log.Logger {
@@ -312,3 +777,17 @@ func (f *framework) GetLogger() log.Logger {
 func (f *framework) GetTaskID() uint64 {
 	return f.taskID
 }
+
+func (f *framework) HasCapability(peerID uint64, cap string) bool {
+	if f.caps == nil {
+		return false
+	}
+	return f.caps.HasCapability(peerID, cap)
+}
+
+func (f *framework) MinClusterCapability(cap string) bool {
+	if f.caps == nil {
+		return false
+	}
+	return f.caps.MinClusterCapability(cap)
+}
@@ -0,0 +1,186 @@
+// Package capability implements a small version/feature negotiation
+// mechanism between framework peers, modelled on etcd's own
+// etcdserver/api/capability.go: each member publishes its build version
+// and a set of supported feature strings into etcd at startup, and peers
+// watch that keyspace to decide whether it is safe to use an optional
+// feature against a given peer, or against the cluster as a whole.
+package capability
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+// Set is one member's build version plus the feature strings it supports,
+// e.g. "gzip-data", "grpc-v1", "backup-update".
+type Set struct {
+	Version string
+	Caps    map[string]bool
+}
+
+// NewSet builds a Set from a version string and a list of capabilities.
+func NewSet(version string, caps ...string) *Set {
+	s := &Set{Version: version, Caps: make(map[string]bool, len(caps))}
+	for _, c := range caps {
+		s.Caps[c] = true
+	}
+	return s
+}
+
+// Has reports whether the set advertises cap. A nil Set has none.
+func (s *Set) Has(cap string) bool {
+	return s != nil && s.Caps[cap]
+}
+
+func (s *Set) encode() string {
+	caps := make([]string, 0, len(s.Caps))
+	for c := range s.Caps {
+		caps = append(caps, c)
+	}
+	return s.Version + "|" + strings.Join(caps, ",")
+}
+
+func decode(raw string) (*Set, error) {
+	parts := strings.SplitN(raw, "|", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("capability: malformed payload %q", raw)
+	}
+	s := &Set{Version: parts[0], Caps: make(map[string]bool)}
+	if parts[1] != "" {
+		for _, c := range strings.Split(parts[1], ",") {
+			s.Caps[c] = true
+		}
+	}
+	return s, nil
+}
+
+// MemberCapsPath returns the key a member's capability set is published
+// under.
+func MemberCapsPath(name string, taskID uint64) string {
+	return fmt.Sprintf("/%s/members/%d/caps", name, taskID)
+}
+
+// MemberCapsPrefix returns the keyspace prefix covering every member's
+// capability set for name.
+func MemberCapsPrefix(name string) string {
+	return fmt.Sprintf("/%s/members/", name)
+}
+
+// Publish advertises this member's capability set into etcd at startup.
+func Publish(ctx context.Context, client *clientv3.Client, name string, taskID uint64, set *Set) error {
+	_, err := client.Put(ctx, MemberCapsPath(name, taskID), set.encode())
+	return err
+}
+
+// Registry tracks every member's published capability set, recomputing
+// cluster-wide capability as members join or leave.
+type Registry struct {
+	client *clientv3.Client
+	name   string
+
+	mu     sync.RWMutex
+	loaded bool
+	peers  map[uint64]*Set
+}
+
+// NewRegistry creates an empty Registry; call Watch to populate and keep
+// it up to date.
+func NewRegistry(client *clientv3.Client, name string) *Registry {
+	return &Registry{client: client, name: name, peers: make(map[uint64]*Set)}
+}
+
+// Watch loads the current members' capability sets and then keeps the
+// registry up to date until ctx is cancelled.
+func (r *Registry) Watch(ctx context.Context) error {
+	prefix := MemberCapsPrefix(r.name)
+	resp, err := r.client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	for _, kv := range resp.Kvs {
+		if taskID, ok := parseMemberID(prefix, string(kv.Key)); ok {
+			if set, err := decode(string(kv.Value)); err == nil {
+				r.peers[taskID] = set
+			}
+		}
+	}
+	r.loaded = true
+	r.mu.Unlock()
+
+	rch := r.client.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1))
+	for {
+		select {
+		case wresp, ok := <-rch:
+			if !ok {
+				return ctx.Err()
+			}
+			if wresp.Err() != nil {
+				return wresp.Err()
+			}
+			r.mu.Lock()
+			for _, ev := range wresp.Events {
+				taskID, ok := parseMemberID(prefix, string(ev.Kv.Key))
+				if !ok {
+					continue
+				}
+				switch ev.Type {
+				case mvccpb.PUT:
+					if set, err := decode(string(ev.Kv.Value)); err == nil {
+						r.peers[taskID] = set
+					}
+				case mvccpb.DELETE:
+					delete(r.peers, taskID)
+				}
+			}
+			r.mu.Unlock()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// HasCapability reports whether peerID has advertised cap.
+func (r *Registry) HasCapability(peerID uint64, cap string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.peers[peerID].Has(cap)
+}
+
+// MinClusterCapability reports whether every currently known member
+// advertises cap. It returns false, not vacuously true, before the
+// initial Get in Watch has completed or while no members are known yet —
+// callers must not treat an unpopulated registry as cluster-wide support.
+func (r *Registry) MinClusterCapability(cap string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if !r.loaded || len(r.peers) == 0 {
+		return false
+	}
+	for _, set := range r.peers {
+		if !set.Has(cap) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseMemberID(prefix, key string) (uint64, bool) {
+	const suffix = "/caps"
+	if !strings.HasPrefix(key, prefix) || !strings.HasSuffix(key, suffix) {
+		return 0, false
+	}
+	idStr := key[len(prefix) : len(key)-len(suffix)]
+	var taskID uint64
+	if _, err := fmt.Sscanf(idStr, "%d", &taskID); err != nil {
+		return 0, false
+	}
+	return taskID, true
+}
@@ -0,0 +1,170 @@
+package etcdutil
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"golang.org/x/net/context"
+
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/clientv3/concurrency"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+// FailedTaskPath returns the key under which taskID's failure is recorded
+// once it is observed, via ReportFailure, to be down.
+func FailedTaskPath(name string, taskID uint64) string {
+	return fmt.Sprintf("/%s/failedTasks/%d", name, taskID)
+}
+
+// FailedTaskPrefix returns the keyspace prefix watched by WaitFailure.
+func FailedTaskPrefix(name string) string {
+	return fmt.Sprintf("/%s/failedTasks/", name)
+}
+
+// EpochPath returns the key under which the current topology epoch for
+// name is published by the elected coordinator.
+func EpochPath(name string) string {
+	return fmt.Sprintf("/%s/epoch", name)
+}
+
+// LeaderPath returns the election prefix campaigned on by coordinators for
+// name.
+func LeaderPath(name string) string {
+	return fmt.Sprintf("/%s/leader", name)
+}
+
+// ReportFailure records that taskID has failed, so that WaitFailure
+// (typically running inside the elected coordinator) can react to it. It
+// is a no-op if the failure has already been reported.
+func ReportFailure(ctx context.Context, client *clientv3.Client, name string, taskID uint64) error {
+	key := FailedTaskPath(name, taskID)
+	_, err := client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, "")).
+		Commit()
+	return err
+}
+
+// WaitFailure blocks until it observes a hint of task failure under name
+// and returns the ID of the first failed task it sees.
+func WaitFailure(ctx context.Context, client *clientv3.Client, name string) (uint64, error) {
+	prefix := FailedTaskPrefix(name)
+	resp, err := client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return 0, err
+	}
+	for _, kv := range resp.Kvs {
+		if taskID, ok := parseFailedTaskID(prefix, string(kv.Key)); ok {
+			return taskID, nil
+		}
+	}
+
+	rch := client.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1))
+	for {
+		select {
+		case wresp, ok := <-rch:
+			if !ok {
+				return 0, ctx.Err()
+			}
+			if wresp.Err() != nil {
+				return 0, wresp.Err()
+			}
+			for _, ev := range wresp.Events {
+				if ev.Type != mvccpb.PUT {
+					continue
+				}
+				if taskID, ok := parseFailedTaskID(prefix, string(ev.Kv.Key)); ok {
+					return taskID, nil
+				}
+			}
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+func parseFailedTaskID(prefix, key string) (uint64, bool) {
+	taskID, err := strconv.ParseUint(key[len(prefix):], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return taskID, true
+}
+
+// Coordinator campaigns for leadership over name and, once elected,
+// consumes the failed-task stream reported via ReportFailure and
+// reassigns roles by publishing a new topology epoch to EpochPath. The
+// actual reassignment policy is application-specific and left to
+// Reassign; the coordinator only owns the etcd plumbing around the
+// election and the epoch bump.
+type Coordinator struct {
+	client   *clientv3.Client
+	name     string
+	Reassign func(failedTaskID uint64, epoch uint64) uint64
+}
+
+// NewCoordinator creates a Coordinator for name. reassign, given the
+// failed task and the next epoch number, returns the epoch to publish;
+// a nil reassign just bumps the epoch by one.
+func NewCoordinator(client *clientv3.Client, name string, reassign func(failedTaskID uint64, epoch uint64) uint64) *Coordinator {
+	return &Coordinator{client: client, name: name, Reassign: reassign}
+}
+
+// Run campaigns for leadership over c.name and, once elected, loops
+// reassigning roles on every reported failure until ctx is cancelled or
+// the session is lost.
+func (c *Coordinator) Run(ctx context.Context) error {
+	session, err := concurrency.NewSession(c.client, concurrency.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	election := concurrency.NewElection(session, LeaderPath(c.name))
+	if err := election.Campaign(ctx, strconv.FormatInt(int64(session.Lease()), 10)); err != nil {
+		return err
+	}
+	defer election.Resign(context.Background())
+
+	for {
+		failedTaskID, err := WaitFailure(ctx, c.client, c.name)
+		if err != nil {
+			return err
+		}
+
+		epoch, err := c.bumpEpoch(ctx, failedTaskID)
+		if err != nil {
+			return err
+		}
+		// Consume the failure now that it has been reassigned, so the
+		// next WaitFailure doesn't keep reporting the same taskID
+		// forever. A task that fails again later re-reports through
+		// ReportFailure, which is a no-op only while its key is still
+		// present.
+		if _, err := c.client.Delete(ctx, FailedTaskPath(c.name, failedTaskID)); err != nil {
+			return err
+		}
+		log.Printf("etcdutil: reassigned failed task %d at epoch %d", failedTaskID, epoch)
+	}
+}
+
+func (c *Coordinator) bumpEpoch(ctx context.Context, failedTaskID uint64) (uint64, error) {
+	resp, err := c.client.Get(ctx, EpochPath(c.name))
+	if err != nil {
+		return 0, err
+	}
+	var epoch uint64
+	if len(resp.Kvs) > 0 {
+		epoch, _ = strconv.ParseUint(string(resp.Kvs[0].Value), 10, 64)
+	}
+	epoch++
+	if c.Reassign != nil {
+		epoch = c.Reassign(failedTaskID, epoch)
+	}
+	if _, err := c.client.Put(ctx, EpochPath(c.name), strconv.FormatUint(epoch, 10)); err != nil {
+		return 0, err
+	}
+	return epoch, nil
+}
@@ -1,60 +1,89 @@
 package etcdutil
 
 import (
-	"math"
 	"time"
 
-	"github.com/coreos/go-etcd/etcd"
+	"golang.org/x/net/context"
+
+	"go.etcd.io/etcd/clientv3"
 )
 
-// heartbeat to etcd cluster until stop
-func Heartbeat(client *etcd.Client, name string, taskID uint64, interval time.Duration, stop chan struct{}) error {
+// heartbeat to etcd cluster until ctx is done. It grants a lease whose TTL
+// is derived from interval, attaches HealthyPath to that lease, and keeps
+// the lease alive in the background for as long as the task is healthy.
+// Once ctx is cancelled, the keepalive stops and the lease is left to
+// expire, which is what DetectFailure watches for.
+func Heartbeat(ctx context.Context, client *clientv3.Client, name string, taskID uint64, interval time.Duration) error {
+	lease, err := client.Grant(ctx, int64(computeTTL(interval)))
+	if err != nil {
+		return err
+	}
+
+	if _, err := client.Put(ctx, HealthyPath(name, taskID), "health", clientv3.WithLease(lease.ID)); err != nil {
+		return err
+	}
+
+	keepAlive, err := client.KeepAlive(ctx, lease.ID)
+	if err != nil {
+		return err
+	}
+
 	for {
-		_, err := client.Set(HealthyPath(name, taskID), "health", computeTTL(interval))
-		if err != nil {
-			return err
-		}
 		select {
-		case <-time.After(interval):
-		case <-stop:
+		case _, ok := <-keepAlive:
+			if !ok {
+				return nil
+			}
+		case <-ctx.Done():
 			return nil
 		}
 	}
 }
 
-// detect failure of the given taskID
-func DetectFailure(client *etcd.Client, name string, taskID uint64, stop chan bool) (uint64, error) {
+// detect failure of the given taskID. It watches HealthyPath for the DELETE
+// event fired when the owning lease expires, which happens once the task
+// stops heartbeating. It returns early with a nil error if ctx is done
+// before a failure is observed.
+func DetectFailure(ctx context.Context, client *clientv3.Client, name string, taskID uint64) (uint64, error) {
 	key := HealthyPath(name, taskID)
-	resp, err := client.Get(key, false, false)
+	resp, err := client.Get(ctx, key)
 	if err != nil {
-		// TODO: should check "key not found"
+		return 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		// Already gone; treat as an immediate failure.
 		return taskID, nil
 	}
-	waitIndex := resp.EtcdIndex + 1
+
+	rch := client.Watch(ctx, key, clientv3.WithRev(resp.Header.Revision+1))
 	for {
-		resp, err = client.Watch(key, waitIndex, false, nil, stop)
-		if err != nil {
-			// on client closing
-			return 0, err
-		}
-		if resp.Action == "delete" || resp.Action == "expire" {
-			return taskID, nil
+		select {
+		case wresp, ok := <-rch:
+			if !ok {
+				return 0, nil
+			}
+			if wresp.Err() != nil {
+				return 0, wresp.Err()
+			}
+			for _, ev := range wresp.Events {
+				if ev.Type == clientv3.EventTypeDelete {
+					return taskID, nil
+				}
+			}
+		case <-ctx.Done():
+			return 0, nil
 		}
-		waitIndex = resp.EtcdIndex + 1
 	}
 }
 
-// report failure to etcd cluster
-// If a framework detects a failure, it tries to report failure to /failedTasks/{taskID}
-func ReportFailure(client *etcd.Client, name string, taskID uint64) {
-
-}
-
-// WaitFailure blocks until it gets a hint of taks failure
-func WaitFailure(client *etcd.Client, name string) uint64 {
-	return 1
+// computeTTL derives a lease TTL, in seconds, from the heartbeat interval.
+// The lease is given generous slack over the interval so that a handful of
+// missed heartbeats don't spuriously trip failure detection, while never
+// dropping below 1 second.
+func computeTTL(interval time.Duration) int64 {
+	ttl := int64(5 * interval / time.Second)
+	if ttl < 1 {
+		return 1
+	}
+	return ttl
 }
-
-func computeTTL(interval time.Duration) uint64 {
-	return uint64(math.Min(5*float64(interval/time.Second), 1))
-}
\ No newline at end of file